@@ -0,0 +1,114 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+// Package graceful provides zero-downtime process restarts for servers
+// built on netex, by inheriting listening sockets across an exec of the
+// running executable, in the style of endless/caddy-style graceful
+// restarts.
+package graceful
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// EnvListenerFDs is the name of the environment variable a parent sets, to
+// the number of listener fds it passed, when starting a restarted child
+// process. IsChild checks only that it is set; the count is not read back.
+const EnvListenerFDs = "NETEX_LISTENER_FDS"
+
+// firstInheritedFD is the fd number of the first inherited listener file,
+// following stdin, stdout and stderr in os.ProcAttr.Files.
+const firstInheritedFD = 3
+
+// Manager coordinates a zero-downtime restart of one or more listening
+// sockets owned by the current process.
+type Manager struct {
+	files []*os.File
+}
+
+// NewManager creates a new, empty restart Manager.
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// IsChild reports whether the current process was started by a Restart
+// call to inherit listening sockets from a parent process.
+func IsChild() bool {
+	_, ok := os.LookupEnv(EnvListenerFDs)
+	return ok
+}
+
+// IsChild reports whether the current process was started by a Restart
+// call to inherit listening sockets from a parent process.
+func (m *Manager) IsChild() bool {
+	return IsChild()
+}
+
+// Add registers a listener file to be inherited by the next restarted
+// child process. Files are inherited in the order they were added, so
+// callers with multiple listeners must Add them in the same order on
+// every restart.
+func (m *Manager) Add(f *os.File) {
+	m.files = append(m.files, f)
+}
+
+// InheritedFile returns the file for the inherited listener at index,
+// among the listener fds passed by a parent process via Restart. It does
+// not check that the fd is open or is a listener, so outside a child
+// started by Restart the returned file is not an inherited listener;
+// check IsChild first.
+func InheritedFile(index int) *os.File {
+	return os.NewFile(uintptr(firstInheritedFD+index), "netex-inherited-listener")
+}
+
+// InheritedListener returns the net.Listener backed by the inherited
+// listener file at index. The returned listener owns a dup of the fd; on
+// success the original inherited file is closed before this returns.
+func InheritedListener(index int) (net.Listener, error) {
+	f := InheritedFile(index)
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return l, nil
+}
+
+// InstallSignalHandler installs a handler that invokes restart on SIGHUP
+// and shutdown on SIGINT or SIGTERM. It returns a function that stops
+// handling the signals; the caller should defer it.
+func (m *Manager) InstallSignalHandler(restart func() error, shutdown func()) func() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-c:
+				switch sig {
+				case syscall.SIGHUP:
+					restart()
+				case syscall.SIGINT, syscall.SIGTERM:
+					shutdown()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// envVar formats the EnvListenerFDs environment variable assignment for n
+// inherited listener files.
+func envVar(n int) string {
+	return EnvListenerFDs + "=" + strconv.Itoa(n)
+}
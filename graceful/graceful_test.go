@@ -0,0 +1,61 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package graceful
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsChild(t *testing.T) {
+	os.Unsetenv(EnvListenerFDs)
+	if IsChild() {
+		t.Fatal("IsChild reported true without " + EnvListenerFDs + " set")
+	}
+
+	os.Setenv(EnvListenerFDs, "1")
+	defer os.Unsetenv(EnvListenerFDs)
+	if !IsChild() {
+		t.Fatal("IsChild reported false with " + EnvListenerFDs + " set")
+	}
+
+	m := NewManager()
+	if !m.IsChild() {
+		t.Fatal("Manager.IsChild reported false with " + EnvListenerFDs + " set")
+	}
+}
+
+func TestManagerAddOrder(t *testing.T) {
+	m := NewManager()
+	a, b := os.Stdin, os.Stdout
+	m.Add(a)
+	m.Add(b)
+	if len(m.files) != 2 || m.files[0] != a || m.files[1] != b {
+		t.Fatal("Add did not register files in call order")
+	}
+}
+
+func TestInheritedFile(t *testing.T) {
+	f := InheritedFile(0)
+	if f.Fd() != firstInheritedFD {
+		t.Fatalf("InheritedFile(0) fd = %d, want %d", f.Fd(), firstInheritedFD)
+	}
+	f = InheritedFile(2)
+	if f.Fd() != firstInheritedFD+2 {
+		t.Fatalf("InheritedFile(2) fd = %d, want %d", f.Fd(), firstInheritedFD+2)
+	}
+}
+
+func TestEnvVar(t *testing.T) {
+	if got, want := envVar(3), EnvListenerFDs+"=3"; got != want {
+		t.Fatalf("envVar(3) = %q, want %q", got, want)
+	}
+}
+
+func TestInstallSignalHandler(t *testing.T) {
+	m := NewManager()
+	stop := m.InstallSignalHandler(func() error { return nil }, func() {})
+	stop()
+}
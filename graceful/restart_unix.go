@@ -0,0 +1,27 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package graceful
+
+import "os"
+
+// Restart starts a copy of the running executable, passing it the
+// listener files registered via Add so the child can resume accepting
+// connections on the same sockets via InheritedListener. It returns once
+// the child has been started; the caller is responsible for draining and
+// shutting down the current process afterwards.
+func (m *Manager) Restart() (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	env := append(os.Environ(), envVar(len(m.files)))
+	files := append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, m.files...)
+	return os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: files,
+	})
+}
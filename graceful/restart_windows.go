@@ -0,0 +1,25 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+//go:build windows
+
+package graceful
+
+import "os"
+
+// Restart starts a copy of the running executable. Windows has no POSIX
+// fd-inheritance equivalent, so this is a quick forceful restart: the
+// child binds fresh listeners of its own and Add'ed files are ignored,
+// leaving a brief gap in availability while the old process drains and
+// exits.
+func (m *Manager) Restart() (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Env:   os.Environ(),
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package middleware
+
+import (
+	"net"
+
+	"github.com/vedranvuk/netex"
+)
+
+// IPAllowList returns a netex.ConnMiddleware that dispatches a connection
+// to the wrapped handler only if its remote IP is contained in one of
+// networks; other connections are closed.
+func IPAllowList(networks ...*net.IPNet) netex.ConnMiddleware {
+	return func(next netex.ConnHandler) netex.ConnHandler {
+		return netex.ConnHandlerFunc(func(conn net.Conn) {
+			if !ipInAny(conn, networks) {
+				conn.Close()
+				return
+			}
+			next.HandleConn(conn)
+		})
+	}
+}
+
+// IPDenyList returns a netex.ConnMiddleware that closes a connection
+// instead of dispatching it to the wrapped handler if its remote IP is
+// contained in one of networks.
+func IPDenyList(networks ...*net.IPNet) netex.ConnMiddleware {
+	return func(next netex.ConnHandler) netex.ConnHandler {
+		return netex.ConnHandlerFunc(func(conn net.Conn) {
+			if ipInAny(conn, networks) {
+				conn.Close()
+				return
+			}
+			next.HandleConn(conn)
+		})
+	}
+}
+
+// ipInAny reports whether conn's remote IP is contained in any of
+// networks.
+func ipInAny(conn net.Conn, networks []*net.IPNet) bool {
+	ip := net.ParseIP(remoteHost(conn))
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+// Package middleware provides a set of built-in netex.ConnMiddleware for
+// use with StreamServer.Use: logging, panic recovery, deadlines, rate
+// limiting, IP filtering and PROXY protocol support.
+package middleware
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/vedranvuk/netex"
+)
+
+// Logger returns a netex.ConnMiddleware that logs each connection's
+// remote address, bytes read, bytes written and handling duration to
+// logger once the wrapped handler returns. A nil logger logs via
+// log.Default().
+func Logger(logger *log.Logger) netex.ConnMiddleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next netex.ConnHandler) netex.ConnHandler {
+		return netex.ConnHandlerFunc(func(conn net.Conn) {
+			cc := &countingConn{Conn: conn}
+			start := time.Now()
+			next.HandleConn(cc)
+			logger.Printf("%s read=%d written=%d duration=%s",
+				conn.RemoteAddr(), atomic.LoadInt64(&cc.read),
+				atomic.LoadInt64(&cc.written), time.Since(start))
+		})
+	}
+}
+
+// countingConn wraps a net.Conn, counting bytes read and written through
+// it.
+type countingConn struct {
+	net.Conn
+
+	read    int64
+	written int64
+}
+
+// Read implements io.Reader on countingConn, tallying bytes read.
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+// Write implements io.Writer on countingConn, tallying bytes written.
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+// Recover returns a netex.ConnMiddleware that recovers a panic in the
+// wrapped handler, logs it to logger (or log.Default() if nil) and closes
+// the connection, instead of letting the panic take down the process.
+func Recover(logger *log.Logger) netex.ConnMiddleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next netex.ConnHandler) netex.ConnHandler {
+		return netex.ConnHandlerFunc(func(conn net.Conn) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Printf("%s: recovered: %v", conn.RemoteAddr(), r)
+					conn.Close()
+				}
+			}()
+			next.HandleConn(conn)
+		})
+	}
+}
+
+// Timeout returns a netex.ConnMiddleware that sets a read and write
+// deadline of d on each connection before dispatching it to the wrapped
+// handler. A d of 0 leaves the connection without a deadline.
+func Timeout(d time.Duration) netex.ConnMiddleware {
+	return func(next netex.ConnHandler) netex.ConnHandler {
+		return netex.ConnHandlerFunc(func(conn net.Conn) {
+			if d > 0 {
+				conn.SetDeadline(time.Now().Add(d))
+			}
+			next.HandleConn(conn)
+		})
+	}
+}
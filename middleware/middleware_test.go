@@ -0,0 +1,298 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vedranvuk/netex"
+)
+
+type pipeConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func dial(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	client, server = net.Pipe()
+	return
+}
+
+func TestRecover(t *testing.T) {
+	client, server := dial(t)
+	defer client.Close()
+
+	handler := Recover(nil)(netex.ConnHandlerFunc(func(conn net.Conn) {
+		panic("boom")
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.HandleConn(server)
+		close(done)
+	}()
+
+	buf := make([]byte, 1)
+	if _, err := client.Read(buf); err == nil {
+		t.Fatal("expected connection to be closed after recovered panic")
+	}
+	<-done
+}
+
+func TestIPAllowList(t *testing.T) {
+	_, cidr, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	handler := IPAllowList(cidr)(netex.ConnHandlerFunc(func(conn net.Conn) {
+		called = true
+	}))
+
+	client, server := dial(t)
+	defer client.Close()
+	pc := &pipeConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1234}}
+	handler.HandleConn(pc)
+	if called {
+		t.Fatal("IPAllowList dispatched a connection outside the allowed network")
+	}
+
+	called = false
+	client2, server2 := dial(t)
+	defer client2.Close()
+	pc2 := &pipeConn{Conn: server2, remote: &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234}}
+	handler.HandleConn(pc2)
+	if !called {
+		t.Fatal("IPAllowList did not dispatch a connection inside the allowed network")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+
+	client, server := dial(t)
+	defer client.Close()
+	pc := &pipeConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4242}}
+
+	handler := Logger(logger)(netex.ConnHandlerFunc(func(conn net.Conn) {
+		in := make([]byte, 4)
+		io.ReadFull(conn, in)
+		conn.Write([]byte("pong"))
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.HandleConn(pc)
+		close(done)
+	}()
+
+	client.Write([]byte("ping"))
+	out := make([]byte, 4)
+	io.ReadFull(client, out)
+	<-done
+
+	got := buf.String()
+	if !strings.Contains(got, "127.0.0.1:4242") {
+		t.Fatalf("log output missing remote addr: %q", got)
+	}
+	if !strings.Contains(got, "read=4") || !strings.Contains(got, "written=4") {
+		t.Fatalf("log output missing byte counts: %q", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	client, server := dial(t)
+	defer client.Close()
+
+	handler := Timeout(20 * time.Millisecond)(netex.ConnHandlerFunc(func(conn net.Conn) {
+		buf := make([]byte, 1)
+		if _, err := conn.Read(buf); err == nil {
+			t.Error("expected a deadline exceeded error")
+		}
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.HandleConn(server)
+		close(done)
+	}()
+	<-done
+}
+
+func TestRateLimit(t *testing.T) {
+	var calls int32
+	handler := RateLimit(0, 1)(netex.ConnHandlerFunc(func(conn net.Conn) {
+		atomic.AddInt32(&calls, 1)
+	}))
+
+	client1, server1 := dial(t)
+	defer client1.Close()
+	pc1 := &pipeConn{Conn: server1, remote: &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 1}}
+	handler.HandleConn(pc1)
+
+	client2, server2 := dial(t)
+	defer client2.Close()
+	pc2 := &pipeConn{Conn: server2, remote: &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 2}}
+	handler.HandleConn(pc2)
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("handler dispatched %d times for a burst of 1, want 1", got)
+	}
+}
+
+func TestIPBucketsSweep(t *testing.T) {
+	b := &ipBuckets{rate: 1, burst: 1, m: make(map[string]*tokenBucket)}
+	b.allow("203.0.113.1")
+	b.allow("203.0.113.2")
+	if len(b.m) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(b.m))
+	}
+
+	b.sweep(time.Now().Add(bucketIdleTTL + time.Second))
+	if len(b.m) != 0 {
+		t.Fatalf("got %d buckets after sweeping past bucketIdleTTL, want 0", len(b.m))
+	}
+}
+
+func TestPROXYProtocolV1(t *testing.T) {
+	client, server := dial(t)
+	defer client.Close()
+
+	var gotRemote net.Addr
+	var gotPayload string
+	handler := PROXYProtocol()(netex.ConnHandlerFunc(func(conn net.Conn) {
+		gotRemote = conn.RemoteAddr()
+		buf := make([]byte, 7)
+		io.ReadFull(conn, buf)
+		gotPayload = string(buf)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.HandleConn(server)
+		close(done)
+	}()
+
+	client.Write([]byte("PROXY TCP4 198.51.100.1 203.0.113.1 56324 443\r\nPAYLOAD"))
+	<-done
+
+	if gotRemote == nil || gotRemote.String() != "198.51.100.1:56324" {
+		t.Fatalf("got remote addr %v, want 198.51.100.1:56324", gotRemote)
+	}
+	if gotPayload != "PAYLOAD" {
+		t.Fatalf("got payload %q, want %q", gotPayload, "PAYLOAD")
+	}
+}
+
+func TestPROXYProtocolV2(t *testing.T) {
+	client, server := dial(t)
+	defer client.Close()
+
+	addr := make([]byte, 12)
+	copy(addr[0:4], net.ParseIP("198.51.100.7").To4())
+	copy(addr[4:8], net.ParseIP("203.0.113.7").To4())
+	binary.BigEndian.PutUint16(addr[8:10], 56324)
+	binary.BigEndian.PutUint16(addr[10:12], 443)
+
+	header := append([]byte{}, proxyV2Sig...)
+	header = append(header, 0x21, 0x11) // version 2, PROXY command; AF_INET, STREAM
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(addr)))
+	header = append(header, lenBuf...)
+	header = append(header, addr...)
+	header = append(header, []byte("PAYLOAD")...)
+
+	var gotRemote net.Addr
+	var gotPayload string
+	handler := PROXYProtocol()(netex.ConnHandlerFunc(func(conn net.Conn) {
+		gotRemote = conn.RemoteAddr()
+		buf := make([]byte, 7)
+		io.ReadFull(conn, buf)
+		gotPayload = string(buf)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.HandleConn(server)
+		close(done)
+	}()
+
+	client.Write(header)
+	<-done
+
+	if gotRemote == nil || gotRemote.String() != "198.51.100.7:56324" {
+		t.Fatalf("got remote addr %v, want 198.51.100.7:56324", gotRemote)
+	}
+	if gotPayload != "PAYLOAD" {
+		t.Fatalf("got payload %q, want %q", gotPayload, "PAYLOAD")
+	}
+}
+
+func TestPROXYProtocolOverlongHeader(t *testing.T) {
+	client, server := dial(t)
+	defer client.Close()
+
+	called := false
+	handler := PROXYProtocol()(netex.ConnHandlerFunc(func(conn net.Conn) {
+		called = true
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.HandleConn(server)
+		close(done)
+	}()
+
+	client.Write(bytes.Repeat([]byte("A"), maxProxyV1HeaderLen+10))
+	<-done
+
+	if called {
+		t.Fatal("handler was dispatched despite an oversized PROXY header")
+	}
+	buf := make([]byte, 1)
+	if _, err := client.Read(buf); err == nil {
+		t.Fatal("expected the connection to be closed after a malformed header")
+	}
+}
+
+func TestPROXYProtocolOverlongHeaderEndingInNewline(t *testing.T) {
+	client, server := dial(t)
+	defer client.Close()
+
+	called := false
+	handler := PROXYProtocol()(netex.ConnHandlerFunc(func(conn net.Conn) {
+		called = true
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.HandleConn(server)
+		close(done)
+	}()
+
+	// The byte that pushes the header one past maxProxyV1HeaderLen is
+	// itself the terminating newline, which must still be rejected rather
+	// than accepted because the newline arrived just in time.
+	header := append(bytes.Repeat([]byte("A"), maxProxyV1HeaderLen), '\n')
+	client.Write(header)
+	<-done
+
+	if called {
+		t.Fatal("handler was dispatched despite a header one byte over the limit")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package middleware
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/vedranvuk/errorex"
+	"github.com/vedranvuk/netex"
+)
+
+// proxyV2Sig is the fixed 12 byte signature prefixing a PROXY protocol v2
+// header.
+var proxyV2Sig = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// maxProxyV1HeaderLen is the maximum length of a PROXY protocol v1 header
+// line, including its trailing CRLF, per the spec. A peer that hasn't
+// sent a newline within this many bytes is treated as malformed.
+const maxProxyV1HeaderLen = 107
+
+var (
+	// ErrMiddleware is the base error of the middleware package.
+	ErrMiddleware = errorex.New("middleware")
+
+	// ErrHeaderTooLong is returned when a PROXY protocol v1 header exceeds
+	// maxProxyV1HeaderLen bytes without a terminating newline.
+	ErrHeaderTooLong = ErrMiddleware.WrapFormat("PROXY v1 header exceeds %d bytes")
+
+	// ErrMalformedHeader is returned when a PROXY protocol v1 header does
+	// not have the expected fields.
+	ErrMalformedHeader = ErrMiddleware.WrapFormat("malformed PROXY v1 header: %q")
+
+	// ErrUnsupportedVersion is returned when a PROXY protocol v2 header
+	// declares a version other than 2.
+	ErrUnsupportedVersion = ErrMiddleware.Wrap("unsupported PROXY protocol version")
+
+	// ErrShortAddress is returned when a PROXY protocol v2 header's address
+	// block is too short for its declared address family.
+	ErrShortAddress = ErrMiddleware.WrapFormat("short PROXY v2 %s address")
+)
+
+// PROXYProtocol returns a netex.ConnMiddleware that parses a PROXY
+// protocol v1 or v2 header, as sent by an L4 load balancer in front of
+// the server, from the start of each connection, and passes the wrapped
+// handler a conn whose RemoteAddr reports the original client address
+// instead of the load balancer's. A connection with a malformed or
+// missing header is closed before reaching the handler.
+func PROXYProtocol() netex.ConnMiddleware {
+	return func(next netex.ConnHandler) netex.ConnHandler {
+		return netex.ConnHandlerFunc(func(conn net.Conn) {
+			pc, err := parseProxyProtocol(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			next.HandleConn(pc)
+		})
+	}
+}
+
+// proxyConn wraps a net.Conn, substituting a PROXY-protocol-derived
+// remote address for RemoteAddr and resuming reads from a buffered
+// reader positioned right after the consumed header.
+type proxyConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+// Read implements io.Reader on proxyConn, continuing from the buffered
+// reader left over after header parsing.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the original client address recovered from the
+// PROXY protocol header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// parseProxyProtocol reads a PROXY protocol v1 or v2 header from the
+// start of conn and returns a conn whose RemoteAddr reports the original
+// client address.
+func parseProxyProtocol(conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+	if sig, err := r.Peek(len(proxyV2Sig)); err == nil && string(sig) == string(proxyV2Sig) {
+		return parseProxyV2(conn, r)
+	}
+	return parseProxyV1(conn, r)
+}
+
+// parseProxyV1 parses a PROXY protocol v1 text header, of the form
+// "PROXY TCP4 <src> <dst> <srcport> <dstport>\r\n".
+func parseProxyV1(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	// Read byte by byte up to maxProxyV1HeaderLen instead of
+	// r.ReadString('\n'), which would grow its buffer without bound
+	// against a peer that never sends a newline.
+	var buf []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b)
+		if len(buf) > maxProxyV1HeaderLen {
+			return nil, ErrHeaderTooLong.WrapArgs(maxProxyV1HeaderLen)
+		}
+		if b == '\n' {
+			break
+		}
+	}
+	fields := strings.Fields(strings.TrimRight(string(buf), "\r\n"))
+	if len(fields) < 6 || fields[0] != "PROXY" {
+		return nil, ErrMalformedHeader.WrapArgs(string(buf))
+	}
+	remote, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(fields[2], fields[4]))
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, reader: r, remote: remote}, nil
+}
+
+// parseProxyV2 parses a PROXY protocol v2 binary header.
+func parseProxyV2(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd := header[12]
+	if verCmd>>4 != 2 {
+		return nil, ErrUnsupportedVersion
+	}
+	family := header[13]
+	addr := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return nil, err
+	}
+
+	// A LOCAL command (low nibble 0) carries no address; it is used for
+	// health checks from the proxy itself.
+	if verCmd&0x0F == 0x00 {
+		return &proxyConn{Conn: conn, reader: r, remote: conn.RemoteAddr()}, nil
+	}
+
+	remote := conn.RemoteAddr()
+	switch family >> 4 {
+	case 1: // AF_INET
+		if len(addr) < 12 {
+			return nil, ErrShortAddress.WrapArgs("IPv4")
+		}
+		remote = &net.TCPAddr{
+			IP:   net.IP(addr[0:4]),
+			Port: int(binary.BigEndian.Uint16(addr[8:10])),
+		}
+	case 2: // AF_INET6
+		if len(addr) < 36 {
+			return nil, ErrShortAddress.WrapArgs("IPv6")
+		}
+		remote = &net.TCPAddr{
+			IP:   net.IP(addr[0:16]),
+			Port: int(binary.BigEndian.Uint16(addr[32:34])),
+		}
+	}
+	return &proxyConn{Conn: conn, reader: r, remote: remote}, nil
+}
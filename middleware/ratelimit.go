@@ -0,0 +1,137 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package middleware
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vedranvuk/netex"
+)
+
+const (
+	// bucketIdleTTL is how long a per-IP bucket may sit unused before a
+	// sweep evicts it, bounding memory growth from IPs, such as ones
+	// behind CGNAT or an attacker rotating source addresses, that are
+	// never seen again.
+	bucketIdleTTL = 10 * time.Minute
+	// sweepInterval is the minimum time between eviction sweeps, so allow
+	// doesn't scan the whole bucket map on every call.
+	sweepInterval = time.Minute
+)
+
+// RateLimit returns a netex.ConnMiddleware that enforces a token bucket
+// rate limit per remote IP: up to burst connections may be dispatched to
+// the wrapped handler immediately, refilling at rate per second.
+// Connections exceeding the limit are closed without reaching the
+// handler. Buckets for IPs idle longer than bucketIdleTTL are evicted
+// periodically to bound memory use.
+func RateLimit(rate float64, burst int) netex.ConnMiddleware {
+	buckets := &ipBuckets{
+		rate:  rate,
+		burst: burst,
+		m:     make(map[string]*tokenBucket),
+	}
+	return func(next netex.ConnHandler) netex.ConnHandler {
+		return netex.ConnHandlerFunc(func(conn net.Conn) {
+			if !buckets.allow(remoteHost(conn)) {
+				conn.Close()
+				return
+			}
+			next.HandleConn(conn)
+		})
+	}
+}
+
+// remoteHost returns the host part of conn's remote address, or the
+// whole address if it has no port.
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// ipBuckets holds one tokenBucket per remote IP seen by RateLimit.
+type ipBuckets struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	m         map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// allow reports whether a connection from host may proceed, creating its
+// tokenBucket on first use.
+func (b *ipBuckets) allow(host string) bool {
+	b.mu.Lock()
+	now := time.Now()
+	tb, ok := b.m[host]
+	if !ok {
+		tb = newTokenBucket(b.rate, b.burst)
+		b.m[host] = tb
+	}
+	if now.Sub(b.lastSweep) > sweepInterval {
+		b.sweep(now)
+	}
+	b.mu.Unlock()
+	return tb.take()
+}
+
+// sweep deletes buckets idle longer than bucketIdleTTL. Callers must hold
+// b.mu.
+func (b *ipBuckets) sweep(now time.Time) {
+	for host, tb := range b.m {
+		tb.mu.Lock()
+		idle := now.Sub(tb.last)
+		tb.mu.Unlock()
+		if idle > bucketIdleTTL {
+			delete(b.m, host)
+		}
+	}
+	b.lastSweep = now
+}
+
+// tokenBucket is a simple token bucket rate limiter.
+type tokenBucket struct {
+	rate  float64
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a tokenBucket starting full, refilling at rate
+// tokens per second up to burst.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// take reports whether a token is available, consuming it if so, after
+// refilling for elapsed time since the last call.
+func (tb *tokenBucket) take() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > float64(tb.burst) {
+		tb.tokens = float64(tb.burst)
+	}
+	tb.last = now
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
@@ -7,6 +7,11 @@ package netex
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/vedranvuk/errorex"
 )
@@ -28,3 +33,169 @@ func TLSConfigFromCertificateFile(cert, key string) (*tls.Config, error) {
 	}
 	return tlscfg, nil
 }
+
+// CertKeyPair names a certificate and private key file pair, for loading
+// multiple certificates with TLSConfigFromCertificateFiles.
+type CertKeyPair struct {
+	// CertFile is the path to a PEM encoded certificate file.
+	CertFile string
+	// KeyFile is the path to the PEM encoded private key file matching
+	// CertFile.
+	KeyFile string
+}
+
+// TLSConfigFromCertificateFiles returns a new tls.Config loaded with all
+// specified cert/key pairs. Incoming TLS handshakes select among them by
+// the ClientHello's SNI server name, falling back to the first pair if no
+// name matches, as done by the standard library's tls.Config.
+func TLSConfigFromCertificateFiles(pairs ...CertKeyPair) (*tls.Config, error) {
+	if len(pairs) == 0 {
+		return nil, ErrNetex.Wrap("no certificate pairs given")
+	}
+	certificates := make([]tls.Certificate, 0, len(pairs))
+	for _, pair := range pairs {
+		certificate, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	tlscfg := &tls.Config{
+		Certificates: certificates,
+	}
+	return tlscfg, nil
+}
+
+// CAPoolFromFile reads a PEM file containing one or more certificates and
+// returns an *x509.CertPool populated with all of them, for use as a
+// tls.Config's RootCAs, to trust a private CA, or ClientCAs, to require
+// client certificates signed by it.
+func CAPoolFromFile(file string) (*x509.CertPool, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// CertStore holds a tls.Certificate loaded from a cert/key file pair and
+// periodically reloads it from disk when either file's modification time
+// changes, so a rotated certificate, e.g. a renewed Let's Encrypt cert, is
+// picked up by new handshakes without restarting the server.
+type CertStore struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	done     chan struct{}
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// NewCertStore creates a CertStore loading its certificate from certFile
+// and keyFile, polling their modification times every interval to detect
+// rotation. interval must be greater than zero. It loads the certificate
+// once before returning and fails if that initial load fails.
+func NewCertStore(certFile, keyFile string, interval time.Duration) (*CertStore, error) {
+	if interval <= 0 {
+		return nil, ErrNetex.Wrap("interval must be greater than zero")
+	}
+	cs := &CertStore{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	go cs.watch()
+	return cs, nil
+}
+
+// load reads and parses the certificate and key files and swaps them in.
+func (cs *CertStore) load() error {
+	certInfo, err := os.Stat(cs.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(cs.keyFile)
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(cs.certFile, cs.keyFile)
+	if err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	cs.cert = &certificate
+	cs.certMod = certInfo.ModTime()
+	cs.keyMod = keyInfo.ModTime()
+	cs.mu.Unlock()
+	return nil
+}
+
+// changed reports whether the cert or key file's modification time has
+// changed since the last successful load.
+func (cs *CertStore) changed() bool {
+	certInfo, err := os.Stat(cs.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(cs.keyFile)
+	if err != nil {
+		return false
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return !certInfo.ModTime().Equal(cs.certMod) || !keyInfo.ModTime().Equal(cs.keyMod)
+}
+
+// watch polls for certificate rotation until Close is called.
+func (cs *CertStore) watch() {
+	ticker := time.NewTicker(cs.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if cs.changed() {
+				cs.load()
+			}
+		case <-cs.done:
+			return
+		}
+	}
+}
+
+// GetCertificate returns the currently loaded certificate. It matches the
+// signature of tls.Config.GetCertificate, so a CertStore can be wired in
+// directly: tlsconfig.GetCertificate = store.GetCertificate.
+func (cs *CertStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cert, nil
+}
+
+// Close stops the CertStore's background file watch.
+func (cs *CertStore) Close() error {
+	close(cs.done)
+	return nil
+}
@@ -1,83 +1,156 @@
-// Copyright 2019 Vedran Vuk. All rights reserved.
+// Copyright 2020 Vedran Vuk. All rights reserved.
 // Use of this source code is governed by a MIT
 // license that can be found in the LICENSE file.
 
 package netex
 
 import (
-	"crypto/tls"
-	"errors"
-	"fmt"
-	"io"
-	"log"
-	"net"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
-type Handler struct{}
+// writeSelfSignedCert generates a self-signed certificate and key for
+// commonName and writes them as PEM files under dir, returning their
+// paths.
+func writeSelfSignedCert(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
 
-func (h *Handler) HandleConn(conn net.Conn) {
-	for {
-		buf := make([]byte, 1024)
-		nr, err := conn.Read(buf)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			log.Println(err)
-			return
-		}
-		fmt.Println(string(buf[:nr]))
-		conn.Write([]byte("pong"))
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, commonName+"-cert.pem")
+	keyFile = filepath.Join(dir, commonName+"-key.pem")
+
+	certOut, err := os.Create(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer certOut.Close()
+	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+
+	keyOut, err := os.Create(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyOut.Close()
+	if err := pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestTLSConfigFromCertificateFile(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir(), "example.com")
+
+	cfg, err := TLSConfigFromCertificateFile(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Fatalf("got %d certificates, want 1", len(cfg.Certificates))
 	}
 }
 
-func TestStreamServer(t *testing.T) {
+func TestTLSConfigFromCertificateFiles(t *testing.T) {
+	dir := t.TempDir()
+	cert1, key1 := writeSelfSignedCert(t, dir, "a.example.com")
+	cert2, key2 := writeSelfSignedCert(t, dir, "b.example.com")
 
-	handler := &Handler{}
+	cfg, err := TLSConfigFromCertificateFiles(
+		CertKeyPair{CertFile: cert1, KeyFile: key1},
+		CertKeyPair{CertFile: cert2, KeyFile: key2},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 2 {
+		t.Fatalf("got %d certificates, want 2", len(cfg.Certificates))
+	}
+
+	if _, err := TLSConfigFromCertificateFiles(); err == nil {
+		t.Fatal("expected an error for no certificate pairs")
+	}
+}
+
+func TestCAPoolFromFile(t *testing.T) {
+	certFile, _ := writeSelfSignedCert(t, t.TempDir(), "ca.example.com")
 
-	server := NewStreamServer("tcp", "0.0.0.0:9001", handler)
-	cfg, err := TlsConfigWithCertificate("cert.pem", "key.unencrypted.pem")
+	pool, err := CAPoolFromFile(certFile)
 	if err != nil {
 		t.Fatal(err)
-		return
 	}
-	cfg.InsecureSkipVerify = true
+	if len(pool.Subjects()) != 1 { //nolint:staticcheck
+		t.Fatalf("got %d subjects in pool, want 1", len(pool.Subjects())) //nolint:staticcheck
+	}
+}
 
-	go func() {
-		if err := server.ListenAndServeTLS(cfg); err != nil {
-			t.Fatal(err)
-			return
-		}
-	}()
+func TestNewCertStoreInvalidInterval(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir(), "example.com")
+
+	if _, err := NewCertStore(certFile, keyFile, 0); err == nil {
+		t.Fatal("expected an error for a zero interval")
+	}
+	if _, err := NewCertStore(certFile, keyFile, -time.Second); err == nil {
+		t.Fatal("expected an error for a negative interval")
+	}
+}
 
-	time.Sleep(1 * time.Millisecond)
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "original.example.com")
+
+	store, err := NewCertStore(certFile, keyFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
 
-	go func() {
-		tlscfg := &tls.Config{}
-		tlscfg.InsecureSkipVerify = true
-		conn, err := tls.Dial("tcp", "0.0.0.0:9001", tlscfg)
-		// conn, err := tls.Dial("tcp", "0.0.0.0:9001", nil)
+	first, err := store.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the cert/key with a freshly generated key and certificate
+	// under the same paths, after a short sleep so the files' modification
+	// times move forward and the poll in watch() observes a change.
+	time.Sleep(10 * time.Millisecond)
+	writeSelfSignedCert(t, dir, "original.example.com")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		second, err := store.GetCertificate(nil)
 		if err != nil {
 			t.Fatal(err)
-			return
 		}
-		for i := 0; i < 10000; i++ {
-			conn.Write([]byte("ping"))
-			buf := make([]byte, 1024)
-			nr, err := conn.Read(buf)
-			if err != nil {
-				t.Fatal(err)
-			}
-			fmt.Println(string(buf[:nr]))
+		if len(second.Certificate) > 0 && string(second.Certificate[0]) != string(first.Certificate[0]) {
+			return
 		}
-		fmt.Println("done")
-		conn.Close()
-	}()
-
-	time.Sleep(1 * time.Second)
-	if err := server.Close(); err != nil {
-		t.Fatal(err)
+		time.Sleep(10 * time.Millisecond)
 	}
+	t.Fatal("CertStore did not reload the rotated certificate in time")
 }
@@ -0,0 +1,248 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package netex
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultPacketBufferSize is the default size of the buffer used to read
+// each datagram when PacketServer.SetBufferSize has not been called.
+const defaultPacketBufferSize = 2048
+
+// PacketHandler handles a single datagram received by a PacketServer.
+type PacketHandler interface {
+	// HandlePacket must handle data received from addr on conn. data is
+	// only valid for the duration of the call; implementations that need
+	// it afterwards must copy it.
+	HandlePacket(conn net.PacketConn, addr net.Addr, data []byte)
+}
+
+// PacketHandlerFunc is a prototype of a packet handler function.
+// Appropriate funcs can be cast to this type to implement PacketHandler.
+type PacketHandlerFunc func(net.PacketConn, net.Addr, []byte)
+
+// HandlePacket implements a PacketHandler on PacketHandlerFunc.
+func (phf PacketHandlerFunc) HandlePacket(conn net.PacketConn, addr net.Addr, data []byte) {
+	phf(conn, addr, data)
+}
+
+// PacketListenerFunc creates the net.PacketConn a PacketServer serves on,
+// in place of net.ListenPacket, so protocols layered over a datagram
+// socket, such as DTLS, can be plugged in the way StreamServer's
+// ListenAndServeTLS layers TLS over a stream listener.
+type PacketListenerFunc func(network, addr string) (net.PacketConn, error)
+
+// PacketWorkerMode selects how a PacketServer dispatches received
+// datagrams to its PacketHandler.
+type PacketWorkerMode int
+
+const (
+	// PerPacketGoroutine dispatches each received datagram in its own
+	// goroutine. It is the default.
+	PerPacketGoroutine PacketWorkerMode = iota
+	// FixedWorkerPool dispatches received datagrams to a fixed pool of
+	// worker goroutines consuming from a shared channel, bounding the
+	// number of datagrams handled concurrently.
+	FixedWorkerPool
+)
+
+// packetJob bundles a received datagram for a FixedWorkerPool worker.
+type packetJob struct {
+	addr net.Addr
+	data []byte
+}
+
+// PacketServer is a blocking datagram server. It dispatches datagrams
+// received on a net.PacketConn to a PacketHandler, analogous to how
+// StreamServer dispatches accepted net.Conns to a ConnHandler.
+type PacketServer struct {
+	// network
+	network string
+	// addr is the address on which PacketServer will listen.
+	addr string
+	// mu guards conn, which is written once by serve before the read loop
+	// (and any FixedWorkerPool workers) starts, and read by Close, possibly
+	// from another goroutine while serve is still running.
+	mu sync.RWMutex
+	// conn
+	conn net.PacketConn
+	// handler
+	handler PacketHandler
+	// state indicates server state.
+	state int32 // atomic access.
+
+	// bufferSize is the size of the buffer used to read each datagram.
+	bufferSize int
+	// workerMode selects how received datagrams are dispatched.
+	workerMode PacketWorkerMode
+	// workerCount is the number of workers used in FixedWorkerPool mode.
+	workerCount int
+	// listenPacket creates the conn to serve on. Defaults to
+	// net.ListenPacket.
+	listenPacket PacketListenerFunc
+
+	jobs chan packetJob
+	wg   sync.WaitGroup
+}
+
+// NewPacketServer creates a new datagram server that listens on specified
+// network and addr and dispatches received datagrams to specified
+// handler.
+func NewPacketServer(network, addr string, handler PacketHandler) *PacketServer {
+	p := &PacketServer{
+		network:    network,
+		addr:       addr,
+		handler:    handler,
+		bufferSize: defaultPacketBufferSize,
+	}
+	atomic.StoreInt32(&p.state, int32(StateIdle))
+	return p
+}
+
+// isReady reports if server is idle and ready to run.
+func (s *PacketServer) isReady() bool {
+	return atomic.LoadInt32(&s.state) == int32(StateIdle)
+}
+
+// SetBufferSize sets the size of the buffer used to read each datagram.
+// It must be called before Serve or ListenAndServe.
+func (s *PacketServer) SetBufferSize(n int) {
+	s.bufferSize = n
+}
+
+// SetWorkerPool switches the server to FixedWorkerPool mode with count
+// worker goroutines consuming from a shared channel, instead of the
+// default of spawning a goroutine per datagram. It must be called before
+// Serve or ListenAndServe.
+func (s *PacketServer) SetWorkerPool(count int) {
+	s.workerMode = FixedWorkerPool
+	s.workerCount = count
+}
+
+// SetPacketListener sets the factory used to create the net.PacketConn to
+// serve on, in place of net.ListenPacket, so a protocol layered over UDP,
+// such as DTLS, can be plugged in. It must be called before
+// ListenAndServe.
+func (s *PacketServer) SetPacketListener(fn PacketListenerFunc) {
+	s.listenPacket = fn
+}
+
+// work is a FixedWorkerPool worker goroutine. It consumes datagrams from
+// s.jobs, handling each on conn, until s.jobs is closed.
+func (s *PacketServer) work(conn net.PacketConn) {
+	defer s.wg.Done()
+	for job := range s.jobs {
+		s.handler.HandlePacket(conn, job.addr, job.data)
+	}
+}
+
+// setConn records conn as the server's conn under mu, so a concurrent read
+// by Close is synchronized with it.
+func (s *PacketServer) setConn(conn net.PacketConn) {
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+}
+
+// getConn returns the server's conn, synchronized with setConn.
+func (s *PacketServer) getConn() net.PacketConn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.conn
+}
+
+// serve is the implementation of Serve().
+func (s *PacketServer) serve(conn net.PacketConn) (err error) {
+	s.setConn(conn)
+
+	if s.workerMode == FixedWorkerPool {
+		count := s.workerCount
+		if count <= 0 {
+			count = 1
+		}
+		s.jobs = make(chan packetJob, count)
+		for i := 0; i < count; i++ {
+			s.wg.Add(1)
+			go s.work(conn)
+		}
+	}
+
+	for {
+		buf := make([]byte, s.bufferSize)
+		var n int
+		var addr net.Addr
+		n, addr, err = conn.ReadFrom(buf)
+		if err != nil {
+			// Ignore errors durring shutdown as there is no way to
+			// gracefully unblock a ReadFrom call.
+			if atomic.LoadInt32(&s.state) == int32(StateShuttingDown) {
+				err = nil
+			}
+			break
+		}
+		data := buf[:n]
+		if s.workerMode == FixedWorkerPool {
+			s.jobs <- packetJob{addr: addr, data: data}
+		} else {
+			go s.handler.HandlePacket(conn, addr, data)
+		}
+	}
+
+	if s.workerMode == FixedWorkerPool {
+		close(s.jobs)
+		s.wg.Wait()
+	}
+
+	atomic.StoreInt32(&s.state, int32(StateIdle))
+	return
+}
+
+// Serve serves on specified conn.
+// It blocks until conn is closed or an error occurs.
+func (s *PacketServer) Serve(conn net.PacketConn) (err error) {
+	if !s.isReady() {
+		return ErrAlreadyRunning
+	}
+	atomic.StoreInt32(&s.state, int32(StateRunning))
+	return s.serve(conn)
+}
+
+// ListenAndServe listens on defined server network and addr and blocks
+// until the underlying conn is closed by Close() or an error occurs.
+func (s *PacketServer) ListenAndServe() error {
+	if !s.isReady() {
+		return ErrAlreadyRunning
+	}
+	atomic.StoreInt32(&s.state, int32(StateRunning))
+	listenPacket := s.listenPacket
+	if listenPacket == nil {
+		listenPacket = net.ListenPacket
+	}
+	conn, err := listenPacket(s.network, s.addr)
+	if err != nil {
+		defer atomic.StoreInt32(&s.state, int32(StateIdle))
+		return err
+	}
+	return s.serve(conn)
+}
+
+// Close closes the underlying conn. It does not wait for in-flight
+// handlers to return.
+func (s *PacketServer) Close() error {
+	if atomic.LoadInt32(&s.state) != int32(StateRunning) {
+		return ErrNotRunning
+	}
+	atomic.StoreInt32(&s.state, int32(StateShuttingDown))
+	defer atomic.StoreInt32(&s.state, int32(StateIdle))
+	return s.getConn().Close()
+}
+
+// State returns the server state.
+func (s *PacketServer) State() ServerState {
+	return ServerState(atomic.LoadInt32(&s.state))
+}
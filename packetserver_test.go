@@ -0,0 +1,85 @@
+// Copyright 2020 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package netex
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketServer(t *testing.T) {
+
+	server := NewPacketServer("udp", "0.0.0.0:9002", PacketHandlerFunc(
+		func(conn net.PacketConn, addr net.Addr, data []byte) {
+			conn.WriteTo([]byte("<- pong"), addr)
+		},
+	))
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("udp", "0.0.0.0:9002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("-> ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	nr, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:nr]) != "<- pong" {
+		t.Fatalf("unexpected reply: %q", buf[:nr])
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPacketServerStates(t *testing.T) {
+
+	server := NewPacketServer("no,u", ":9002", nil)
+	if server.State() != StateIdle {
+		t.Fatal("State failed.")
+	}
+
+	if err := server.ListenAndServe(); err != nil {
+		if server.State() != StateIdle {
+			t.Fatal("State failed.")
+		}
+	}
+
+	server = NewPacketServer("udp", ":9183", PacketHandlerFunc(
+		func(conn net.PacketConn, addr net.Addr, data []byte) {},
+	))
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(1 * time.Millisecond)
+
+	if server.State() != StateRunning {
+		t.Fatal("State failed.")
+	}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if server.State() != StateIdle {
+		t.Fatal("State failed.")
+	}
+}
@@ -5,9 +5,15 @@
 package netex
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/vedranvuk/netex/graceful"
 )
 
 var (
@@ -19,6 +25,9 @@ var (
 	// ErrNotRunning is returned when a close is issued on a server that is
 	// not running.
 	ErrNotRunning = ErrStreamServer.Wrap("server not running")
+	// ErrNoListenerFD is returned by Restart when the server's listener
+	// does not support extracting an inheritable fd.
+	ErrNoListenerFD = ErrStreamServer.Wrap("listener does not support fd inheritance")
 )
 
 // ConnHandler is a connection handler.
@@ -37,6 +46,11 @@ func (chf ConnHandlerFunc) HandleConn(conn net.Conn) {
 	chf(conn)
 }
 
+// ConnMiddleware wraps a ConnHandler with additional behavior, such as
+// logging, panic recovery or rate limiting, without the handler needing
+// to know about it.
+type ConnMiddleware func(ConnHandler) ConnHandler
+
 // ServerState is the server state enum type.
 type ServerState int32
 
@@ -73,12 +87,40 @@ type StreamServer struct {
 	network string
 	// addr is the address on which Server will listen for connections.
 	addr string
+	// mu guards listener, which is written once by serve before the accept
+	// loop starts and read by Close, Shutdown and ListenerFile, possibly
+	// from another goroutine while serve is still running.
+	mu sync.RWMutex
 	// listener
 	listener net.Listener
 	// handler
 	handler ConnHandler
 	// state indicates server state.
 	state int32 // atomic access.
+
+	// connLimit bounds the number of connections handled concurrently.
+	// Zero, the default, means no limit.
+	connLimit int
+	// sem bounds concurrent handler goroutines when connLimit is set.
+	sem chan struct{}
+	// keepAlive enables TCP keepalives on accepted TCP connections.
+	keepAlive bool
+	// keepAlivePeriod is the TCP keepalive period used when keepAlive is
+	// enabled. Zero leaves the period the conn was accepted with.
+	keepAlivePeriod time.Duration
+	// inheritedIndex is the index, among the listener fds passed by a
+	// parent process via Restart, that this server resumes on when the
+	// current process is a restarted child. Defaults to 0.
+	inheritedIndex int
+
+	// conns tracks live connections for graceful shutdown.
+	conns sync.Map
+	// wg counts tracked connections not yet closed, for Shutdown.
+	wg sync.WaitGroup
+
+	// middleware is the chain of ConnMiddleware registered via Use, in
+	// registration order.
+	middleware []ConnMiddleware
 }
 
 // NewStreamServer creates a new stream connection server that listens on
@@ -98,9 +140,125 @@ func (s *StreamServer) isReady() bool {
 	return atomic.LoadInt32(&s.state) == int32(StateIdle)
 }
 
+// SetConnectionLimit bounds the number of connections handled concurrently
+// to limit. A limit of 0, the default, means no limit. SetConnectionLimit
+// must be called before Serve, ListenAndServe or ListenAndServeTLS.
+func (s *StreamServer) SetConnectionLimit(limit int) {
+	s.connLimit = limit
+	if limit > 0 {
+		s.sem = make(chan struct{}, limit)
+	} else {
+		s.sem = nil
+	}
+}
+
+// SetKeepAlive enables TCP keepalives on accepted TCP connections,
+// including those served over TLS. Passing false stops the server from
+// enabling them; it does not turn off keepalives the listener already
+// set on accepted connections. It has no effect on non-TCP networks such
+// as unix sockets.
+func (s *StreamServer) SetKeepAlive(enabled bool) {
+	s.keepAlive = enabled
+}
+
+// SetKeepAlivePeriod sets the TCP keepalive period used when keepalives are
+// enabled via SetKeepAlive. A period of 0 leaves the period the connection
+// was accepted with unchanged.
+func (s *StreamServer) SetKeepAlivePeriod(d time.Duration) {
+	s.keepAlivePeriod = d
+}
+
+// SetInheritedIndex sets the index, among the listener fds passed by a
+// parent process via Restart, that this server resumes listening on when
+// the current process is started as a restarted child. It defaults to 0
+// and must be set before ListenAndServe or ListenAndServeTLS.
+//
+// Programs serving multiple listeners that are restarted together must
+// share a single graceful.Manager, Add each server's ListenerFile to it
+// in a fixed order, and give each server the matching index here so the
+// child resumes every listener on the correct fd.
+func (s *StreamServer) SetInheritedIndex(index int) {
+	s.inheritedIndex = index
+}
+
+// Use appends mw to the server's middleware chain. Middlewares are
+// applied in the order given, so the first one passed is outermost: it
+// sees the conn first and the innermost handler's effects last. Use must
+// be called before Serve, ListenAndServe or ListenAndServeTLS.
+func (s *StreamServer) Use(mw ...ConnMiddleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// chain returns s.handler wrapped by all middleware registered via Use,
+// applied so the first one passed to Use ends up outermost.
+func (s *StreamServer) chain() ConnHandler {
+	h := s.handler
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h
+}
+
+// trackedConn wraps an accepted net.Conn so its owning StreamServer can
+// track it for graceful shutdown via Shutdown.
+type trackedConn struct {
+	net.Conn
+
+	server *StreamServer
+	once   sync.Once
+}
+
+// Close closes the wrapped net.Conn and removes it from the owning
+// StreamServer's tracked connections.
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.server.conns.Delete(c)
+		c.server.wg.Done()
+	})
+	return err
+}
+
+// track configures keepalive on conn, if applicable, wraps it for tracking
+// and registers it with s, returning the wrapped conn to hand to a handler.
+func (s *StreamServer) track(conn net.Conn) *trackedConn {
+	if s.keepAlive {
+		raw := conn
+		if tlsconn, ok := conn.(*tls.Conn); ok {
+			raw = tlsconn.NetConn()
+		}
+		if tcpconn, ok := raw.(*net.TCPConn); ok {
+			tcpconn.SetKeepAlive(true)
+			if s.keepAlivePeriod > 0 {
+				tcpconn.SetKeepAlivePeriod(s.keepAlivePeriod)
+			}
+		}
+	}
+	tc := &trackedConn{Conn: conn, server: s}
+	s.wg.Add(1)
+	s.conns.Store(tc, struct{}{})
+	return tc
+}
+
+// setListener records l as the server's listener under mu, so concurrent
+// reads by Close, Shutdown and ListenerFile are synchronized with it.
+func (s *StreamServer) setListener(l net.Listener) {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+}
+
+// getListener returns the server's listener, synchronized with setListener.
+func (s *StreamServer) getListener() net.Listener {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listener
+}
+
 // serve is the implementation of Serve().
 func (s *StreamServer) serve(l net.Listener) (err error) {
-	s.listener = l
+	s.setListener(l)
+	handler := s.chain()
 	for {
 		var conn net.Conn
 		conn, err = l.Accept()
@@ -112,7 +270,16 @@ func (s *StreamServer) serve(l net.Listener) (err error) {
 			}
 			break
 		}
-		go s.handler.HandleConn(conn)
+		tc := s.track(conn)
+		if s.sem != nil {
+			s.sem <- struct{}{}
+		}
+		go func() {
+			if s.sem != nil {
+				defer func() { <-s.sem }()
+			}
+			handler.HandleConn(tc)
+		}()
 	}
 	atomic.StoreInt32(&s.state, int32(StateIdle))
 	return
@@ -128,6 +295,16 @@ func (s *StreamServer) Serve(l net.Listener) (err error) {
 	return s.serve(l)
 }
 
+// listen returns a listener for the server: if the current process was
+// started by Restart to inherit a listening socket, the inherited
+// listener is resumed; otherwise a fresh one is bound.
+func (s *StreamServer) listen() (net.Listener, error) {
+	if graceful.IsChild() {
+		return graceful.InheritedListener(s.inheritedIndex)
+	}
+	return net.Listen(s.network, s.addr)
+}
+
 // ListenAndServe listens on defined Server ListenAddr and blocks until
 // underlying listener returns by Close() or an error occurs.
 func (s *StreamServer) ListenAndServe() error {
@@ -135,7 +312,7 @@ func (s *StreamServer) ListenAndServe() error {
 		return ErrAlreadyRunning
 	}
 	atomic.StoreInt32(&s.state, int32(StateRunning))
-	l, err := net.Listen(s.network, s.addr)
+	l, err := s.listen()
 	if err != nil {
 		defer atomic.StoreInt32(&s.state, int32(StateIdle))
 		return err
@@ -154,11 +331,11 @@ func (s *StreamServer) ListenAndServeTLS(tlsconfig *tls.Config) error {
 		tlsconfig = &tls.Config{}
 	}
 	atomic.StoreInt32(&s.state, int32(StateRunning))
-	l, err := tls.Listen(s.network, s.addr, tlsconfig)
+	l, err := s.listen()
 	if err != nil {
 		return err
 	}
-	return s.serve(l)
+	return s.serve(tls.NewListener(l, tlsconfig))
 }
 
 // Close closes the listener. It does not close any accepted connections.
@@ -168,7 +345,93 @@ func (s *StreamServer) Close() error {
 	}
 	atomic.StoreInt32(&s.state, int32(StateShuttingDown))
 	defer atomic.StoreInt32(&s.state, int32(StateIdle))
-	return s.listener.Close()
+	return s.getListener().Close()
+}
+
+// Shutdown gracefully stops the server, unlike Close. It closes the
+// listener so no new connections are accepted, then waits until every
+// accepted connection has been closed. It does not wait for a handler to
+// return once that handler has closed its connection. If ctx expires
+// before all connections are closed, Shutdown forcibly closes the
+// remaining ones and returns ctx.Err().
+func (s *StreamServer) Shutdown(ctx context.Context) error {
+	if atomic.LoadInt32(&s.state) != int32(StateRunning) {
+		return ErrNotRunning
+	}
+	atomic.StoreInt32(&s.state, int32(StateShuttingDown))
+	defer atomic.StoreInt32(&s.state, int32(StateIdle))
+
+	if err := s.getListener().Close(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// Close via trackedConn.Close, not the embedded net.Conn directly,
+		// so wg.Done is called and done still closes below.
+		s.conns.Range(func(key, _ interface{}) bool {
+			key.(*trackedConn).Close()
+			return true
+		})
+		<-done
+		return ctx.Err()
+	}
+}
+
+// ListenerFile returns a duplicated OS file backing the server's listener,
+// suitable for passing to a graceful.Manager so a restarted child process
+// can inherit it. The caller owns the returned file and must close it.
+// It returns ErrNotRunning if the server isn't running and ErrNoListenerFD
+// if the listener it serves on doesn't support fd inheritance. A server
+// started with ListenAndServeTLS serves on a TLS listener, which has no
+// File method, so ListenerFile, and with it Restart, returns
+// ErrNoListenerFD for it.
+func (s *StreamServer) ListenerFile() (*os.File, error) {
+	if atomic.LoadInt32(&s.state) != int32(StateRunning) {
+		return nil, ErrNotRunning
+	}
+	type filer interface {
+		File() (*os.File, error)
+	}
+	f, ok := s.getListener().(filer)
+	if !ok {
+		return nil, ErrNoListenerFD
+	}
+	return f.File()
+}
+
+// Restart performs a zero-downtime restart of the server: it starts a
+// copy of the running executable, handing it this server's listening
+// socket so the child can resume accepting connections immediately via
+// graceful.InheritedListener, then drains this process by calling
+// Shutdown to let in-flight connections finish.
+//
+// Restart is for the single-listener case, and always targets inherited
+// index 0. Programs serving multiple listeners that must be restarted
+// together should instead share a graceful.Manager, Add each server's
+// ListenerFile to it in a fixed order, call Manager.Restart once, give
+// each server the matching SetInheritedIndex, then Shutdown each server.
+func (s *StreamServer) Restart() error {
+	f, err := s.ListenerFile()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mgr := graceful.NewManager()
+	mgr.Add(f)
+	if _, err := mgr.Restart(); err != nil {
+		return err
+	}
+	return s.Shutdown(context.Background())
 }
 
 // State returns the server state.
@@ -5,6 +5,7 @@
 package netex
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -115,3 +116,80 @@ func TestStreamServerStates(t *testing.T) {
 		t.Fatal("State failed.")
 	}
 }
+
+func TestStreamServerShutdown(t *testing.T) {
+
+	blocking := make(chan struct{})
+	server := NewStreamServer("tcp", ":9183", ConnHandlerFunc(func(c net.Conn) {
+		defer c.Close()
+		<-blocking
+	}))
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", ":9183")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(1 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != ctx.Err() {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if server.State() != StateIdle {
+		t.Fatal("State failed.")
+	}
+	close(blocking)
+}
+
+func TestStreamServerUse(t *testing.T) {
+
+	var order []string
+	done := make(chan struct{})
+	mw := func(name string) ConnMiddleware {
+		return func(next ConnHandler) ConnHandler {
+			return ConnHandlerFunc(func(c net.Conn) {
+				order = append(order, name)
+				next.HandleConn(c)
+			})
+		}
+	}
+
+	server := NewStreamServer("tcp", ":9184", ConnHandlerFunc(func(c net.Conn) {
+		order = append(order, "handler")
+		c.Close()
+		close(done)
+	}))
+	server.Use(mw("first"), mw("second"))
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", ":9184")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	<-done
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "first second handler"
+	if got := fmt.Sprint(order[0], " ", order[1], " ", order[2]); got != want {
+		t.Fatalf("middleware order = %q, want %q", got, want)
+	}
+}